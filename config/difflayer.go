@@ -0,0 +1,43 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com) and other Dragonboat authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "time"
+
+// DiffLayerConfig holds the knobs for the optional in-memory difflayer
+// cache that can sit in front of an IOnDiskStateMachine, see the rsm
+// package. The zero value disables the feature: MaxDiffLayers of 0 means
+// no layer is ever kept in memory, so Lookup/NALookup fall straight
+// through to the underlying state machine exactly as they did before this
+// feature existed.
+type DiffLayerConfig struct {
+	// MaxDiffLayers is the maximum number of in-memory diff layers kept on
+	// top of the disk layer. 0 disables the cache.
+	MaxDiffLayers uint64
+	// DiffLayerBytes is the aggregate size, in bytes, of cached mutations
+	// across all diff layers that triggers dropping the bottom layer from the
+	// cache, regardless of FlushInterval.
+	DiffLayerBytes uint64
+	// FlushInterval is the maximum age of the bottom diff layer before it is
+	// dropped from the cache, regardless of DiffLayerBytes. Every mutation is
+	// applied to the disk state machine by Update itself, so dropping a layer
+	// writes nothing to the disk state machine.
+	FlushInterval time.Duration
+}
+
+// Enabled reports whether the difflayer cache should be used.
+func (c DiffLayerConfig) Enabled() bool {
+	return c.MaxDiffLayers > 0
+}
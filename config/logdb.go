@@ -0,0 +1,167 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com) and other Dragonboat authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// CompressionType is the type of the block compression algorithm used by
+// the LogDB's underlying key-value store.
+type CompressionType uint64
+
+const (
+	// NoCompression disables block compression.
+	NoCompression CompressionType = iota
+	// SnappyCompression selects Snappy block compression.
+	SnappyCompression
+	// ZstdCompression selects Zstd block compression. The pebble backend has
+	// no Zstd support and uses Snappy block compression for it.
+	ZstdCompression
+)
+
+// LogDBConfig is used to configure parameters of the LogDB's underlying
+// key-value storage engine. This is an advanced feature that most users
+// will not need to touch -- see the documentation of each field before
+// changing its default value.
+type LogDBConfig struct {
+	// KVKeepLogFileNum is the number of log files to keep. RocksDB-only --
+	// the pebble backend manages its own WAL file retention and does not
+	// read this field.
+	KVKeepLogFileNum uint64
+	// KVMaxBackgroundCompactions is the maximum number of concurrent
+	// background compactions. RocksDB-only -- pebble sizes its internal
+	// compaction worker pool itself and does not read this field.
+	KVMaxBackgroundCompactions uint64
+	// KVMaxBackgroundFlushes is the maximum number of concurrent background
+	// memtable flushes. RocksDB-only -- pebble does not read this field.
+	KVMaxBackgroundFlushes uint64
+	// KVLRUCacheSize is the size in bytes of the block cache.
+	KVLRUCacheSize uint64
+	// KVWriteBufferSize is the size in bytes of a single memtable.
+	KVWriteBufferSize uint64
+	// KVMaxWriteBufferNumber is the maximum number of memtables, both active
+	// and immutable, kept in memory before writes are stalled.
+	KVMaxWriteBufferNumber uint64
+	// KVLevel0FileNumCompactionTrigger is the number of L0 files that
+	// triggers a compaction of L0 into L1.
+	KVLevel0FileNumCompactionTrigger uint64
+	// KVLevel0SlowdownWritesTrigger is the number of L0 files that starts
+	// slowing down writes.
+	KVLevel0SlowdownWritesTrigger uint64
+	// KVLevel0StopWritesTrigger is the number of L0 files that stops writes
+	// until compaction reduces the count below the trigger.
+	KVLevel0StopWritesTrigger uint64
+	// KVMaxBytesForLevelBase is the maximum total size in bytes of L1.
+	KVMaxBytesForLevelBase uint64
+	// KVTargetFileSizeBase is the target size in bytes of files in L1.
+	KVTargetFileSizeBase uint64
+	// KVTargetFileSizeMultiplier is the target file size multiplier applied
+	// to each additional level below L1.
+	KVTargetFileSizeMultiplier uint64
+	// KVLevelCompactionDynamicLevelBytes enables dynamic level sizing, any
+	// non-zero value turns it on.
+	KVLevelCompactionDynamicLevelBytes uint64
+	// KVRecycleLogFileNum is the number of WAL files kept around for reuse.
+	// RocksDB-only -- pebble does not recycle WAL files and does not read
+	// this field.
+	KVRecycleLogFileNum uint64
+	// KVNumOfLevels is the number of levels used by the store.
+	KVNumOfLevels uint64
+	// KVBlockSize is the approximate size in bytes of a single data block.
+	KVBlockSize uint64
+	// KVMaxOpenFiles is the maximum number of open files the store may keep,
+	// a value of -1 means unlimited.
+	KVMaxOpenFiles int64
+	// KVWALBytesPerSync is the number of bytes to write to a WAL file before
+	// calling fdatasync on it.
+	KVWALBytesPerSync uint64
+	// KVCompressionType is the compression algorithm used for the blocks of
+	// every level, L0 included.
+	KVCompressionType CompressionType
+	// Sync controls whether writes are followed by a fsync, matching the
+	// Sync field of the underlying store's write options. Turning this off
+	// trades Raft log durability for write throughput and must not be used
+	// in production.
+	Sync bool
+}
+
+// GetDefaultLogDBConfig returns the default, balanced LogDBConfig suitable
+// for most production deployments.
+func GetDefaultLogDBConfig() LogDBConfig {
+	return LargeMemProduction
+}
+
+// SmallMemTest is a LogDBConfig profile tuned for running tests on
+// machines with limited memory. It trades write amplification for a much
+// smaller memory footprint.
+var SmallMemTest = LogDBConfig{
+	KVMaxBackgroundCompactions:         2,
+	KVMaxBackgroundFlushes:             2,
+	KVLRUCacheSize:                     8 * 1024 * 1024,
+	KVWriteBufferSize:                  4 * 1024 * 1024,
+	KVMaxWriteBufferNumber:             4,
+	KVLevel0FileNumCompactionTrigger:   4,
+	KVLevel0SlowdownWritesTrigger:      16,
+	KVLevel0StopWritesTrigger:          32,
+	KVMaxBytesForLevelBase:             16 * 1024 * 1024,
+	KVTargetFileSizeBase:               4 * 1024 * 1024,
+	KVTargetFileSizeMultiplier:         2,
+	KVLevelCompactionDynamicLevelBytes: 0,
+	KVRecycleLogFileNum:                4,
+	KVNumOfLevels:                      7,
+	KVBlockSize:                        4 * 1024,
+	KVMaxOpenFiles:                     1000,
+	KVWALBytesPerSync:                  1024 * 1024,
+	KVCompressionType:                  NoCompression,
+	Sync:                               true,
+}
+
+// LargeMemProduction is a LogDBConfig profile tuned for production
+// deployments running on machines with plenty of memory.
+var LargeMemProduction = LogDBConfig{
+	KVMaxBackgroundCompactions:         4,
+	KVMaxBackgroundFlushes:             4,
+	KVLRUCacheSize:                     4 * 1024 * 1024 * 1024,
+	KVWriteBufferSize:                  128 * 1024 * 1024,
+	KVMaxWriteBufferNumber:             8,
+	KVLevel0FileNumCompactionTrigger:   8,
+	KVLevel0SlowdownWritesTrigger:      32,
+	KVLevel0StopWritesTrigger:          64,
+	KVMaxBytesForLevelBase:             4 * 1024 * 1024 * 1024,
+	KVTargetFileSizeBase:               256 * 1024 * 1024,
+	KVTargetFileSizeMultiplier:         2,
+	KVLevelCompactionDynamicLevelBytes: 1,
+	KVRecycleLogFileNum:                16,
+	KVNumOfLevels:                      7,
+	KVBlockSize:                        32 * 1024,
+	KVMaxOpenFiles:                     -1,
+	KVWALBytesPerSync:                  4 * 1024 * 1024,
+	KVCompressionType:                  SnappyCompression,
+	Sync:                               true,
+}
+
+// LogDBPresets maps a preset profile name to its LogDBConfig, used by
+// NewKVStore implementations to resolve a config supplied by name.
+var LogDBPresets = map[string]LogDBConfig{
+	"small-mem-test":       SmallMemTest,
+	"large-mem-production": LargeMemProduction,
+}
+
+// GetLogDBConfig resolves a named preset, returning GetDefaultLogDBConfig's
+// value and false if name is not a known preset.
+func GetLogDBConfig(name string) (LogDBConfig, bool) {
+	cfg, ok := LogDBPresets[name]
+	if !ok {
+		return GetDefaultLogDBConfig(), false
+	}
+	return cfg, true
+}
@@ -0,0 +1,174 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metamorphic implements a randomized testing harness that drives
+// any kv.IKVStore implementation through the same stream of operations so
+// that divergences between backends (or between two option sets of the
+// same backend) can be caught before they reach production.
+package metamorphic
+
+import "math/rand"
+
+// KeySpace describes the distribution used to generate keys and values.
+type KeySpace struct {
+	// MinKeyLen and MaxKeyLen bound the length, in bytes, of generated keys.
+	MinKeyLen int
+	// MaxKeyLen bounds the length, in bytes, of generated keys.
+	MaxKeyLen int
+	// MinValueLen and MaxValueLen bound the length, in bytes, of generated
+	// values.
+	MinValueLen int
+	// MaxValueLen bounds the length, in bytes, of generated values.
+	MaxValueLen int
+	// NumKeys is the size of the fixed key pool ops are drawn from. Reusing
+	// a bounded pool of keys, rather than generating fresh random keys every
+	// time, is what makes the history interesting -- it forces overwrites,
+	// deletes of already deleted keys, range scans with real hits, etc.
+	NumKeys int
+}
+
+// DefaultKeySpace returns a small key space suitable for quick runs.
+func DefaultKeySpace() KeySpace {
+	return KeySpace{
+		MinKeyLen:   1,
+		MaxKeyLen:   16,
+		MinValueLen: 0,
+		MaxValueLen: 256,
+		NumKeys:     256,
+	}
+}
+
+// Config controls how a generator produces its op stream.
+type Config struct {
+	// Seed is the PRNG seed. The same seed with the same Config always
+	// produces the same op stream, which is what allows a failing run to be
+	// reported as a minimizable seed.
+	Seed int64
+	// Ops is the number of operations to generate.
+	Ops int
+	// Weights controls the relative frequency of each op type. The zero
+	// value causes defaultOpWeights to be used.
+	Weights opWeights
+	// KeySpace controls key/value generation.
+	KeySpace KeySpace
+}
+
+// generator produces a deterministic stream of ops from a Config.
+type generator struct {
+	cfg  Config
+	rng  *rand.Rand
+	keys [][]byte
+}
+
+func newGenerator(cfg Config) *generator {
+	if cfg.Weights.total() == 0 {
+		cfg.Weights = defaultOpWeights()
+	}
+	if cfg.KeySpace.NumKeys == 0 {
+		cfg.KeySpace = DefaultKeySpace()
+	}
+	g := &generator{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+	}
+	g.keys = make([][]byte, cfg.KeySpace.NumKeys)
+	for i := range g.keys {
+		g.keys[i] = g.randBytes(cfg.KeySpace.MinKeyLen, cfg.KeySpace.MaxKeyLen)
+	}
+	return g
+}
+
+func (g *generator) randBytes(min int, max int) []byte {
+	n := min
+	if max > min {
+		n += g.rng.Intn(max - min + 1)
+	}
+	b := make([]byte, n)
+	g.rng.Read(b)
+	return b
+}
+
+func (g *generator) randKey() []byte {
+	return g.keys[g.rng.Intn(len(g.keys))]
+}
+
+func (g *generator) randKeyRange() ([]byte, []byte, bool) {
+	i := g.rng.Intn(len(g.keys))
+	j := g.rng.Intn(len(g.keys))
+	fk, lk := g.keys[i], g.keys[j]
+	if string(fk) > string(lk) {
+		fk, lk = lk, fk
+	}
+	return fk, lk, g.rng.Intn(2) == 0
+}
+
+// next picks the next op type according to the configured weights.
+func (g *generator) next() opType {
+	w := g.cfg.Weights
+	n := g.rng.Intn(w.total())
+	for _, e := range []struct {
+		n int
+		t opType
+	}{
+		{w.put, opPut},
+		{w.delete, opDelete},
+		{w.get, opGet},
+		{w.iterateValue, opIterateValue},
+		{w.batchPut, opBatchPut},
+		{w.batchDelete, opBatchDelete},
+		{w.batchCommit, opBatchCommit},
+		{w.removeEntries, opRemoveEntries},
+		{w.compaction, opCompaction},
+		{w.close, opClose},
+		{w.reopen, opReopen},
+	} {
+		if n < e.n {
+			return e.t
+		}
+		n -= e.n
+	}
+	panic("unreachable")
+}
+
+// generate returns the full op stream for this generator's Config.
+func (g *generator) generate() []op {
+	ops := make([]op, 0, g.cfg.Ops)
+	for i := 0; i < g.cfg.Ops; i++ {
+		ops = append(ops, g.generateOp())
+	}
+	return ops
+}
+
+func (g *generator) generateOp() op {
+	switch t := g.next(); t {
+	case opPut, opBatchPut:
+		return op{
+			typ:   t,
+			key:   g.randKey(),
+			value: g.randBytes(g.cfg.KeySpace.MinValueLen, g.cfg.KeySpace.MaxValueLen),
+		}
+	case opDelete, opBatchDelete, opGet:
+		return op{typ: t, key: g.randKey()}
+	case opIterateValue, opCompaction:
+		fk, lk, incl := g.randKeyRange()
+		return op{typ: t, key: fk, lastKey: lk, incl: incl}
+	case opRemoveEntries:
+		fk, lk, _ := g.randKeyRange()
+		return op{typ: t, key: fk, lastKey: lk}
+	case opBatchCommit, opClose, opReopen:
+		return op{typ: t}
+	default:
+		panic("unreachable")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import "fmt"
+
+// entry is a single recorded step in a run's history: the op that was
+// applied plus what was observed as a result. result is the string form of
+// whatever the op returned (a value, an iteration trace, an error, etc.) so
+// that two runs can be compared line-by-line regardless of backend type.
+type entry struct {
+	idx    int
+	op     op
+	result string
+	err    error
+}
+
+func (e entry) String() string {
+	if e.err != nil {
+		return fmt.Sprintf("%d: %s(key=%x) -> error: %v", e.idx, e.op.typ, e.op.key, e.err)
+	}
+	return fmt.Sprintf("%d: %s(key=%x) -> %s", e.idx, e.op.typ, e.op.key, e.result)
+}
+
+// history is the ordered log produced by replaying an op stream against a
+// single store.
+type history struct {
+	name    string
+	entries []entry
+}
+
+func newHistory(name string) *history {
+	return &history{name: name}
+}
+
+func (h *history) record(idx int, o op, result string, err error) {
+	h.entries = append(h.entries, entry{idx: idx, op: o, result: result, err: err})
+}
+
+// divergence describes the first point at which two histories disagree.
+type divergence struct {
+	index int
+	left  entry
+	right entry
+}
+
+func (d divergence) Error() string {
+	return fmt.Sprintf("histories diverge at op %d:\n  %s: %s\n  %s: %s",
+		d.index, "left", d.left.String(), "right", d.right.String())
+}
+
+// compare walks two histories produced from the same op stream and returns
+// the first divergence found, or nil if they agree throughout.
+func compare(left *history, right *history) *divergence {
+	n := len(left.entries)
+	if len(right.entries) < n {
+		n = len(right.entries)
+	}
+	for i := 0; i < n; i++ {
+		l, r := left.entries[i], right.entries[i]
+		if (l.err == nil) != (r.err == nil) {
+			return &divergence{index: i, left: l, right: r}
+		}
+		if l.err == nil && l.result != r.result {
+			return &divergence{index: i, left: l, right: r}
+		}
+	}
+	if len(left.entries) != len(right.entries) {
+		return &divergence{index: n}
+	}
+	return nil
+}
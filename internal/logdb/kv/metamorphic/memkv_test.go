@@ -0,0 +1,258 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lni/dragonboat/v3/internal/logdb/kv"
+	"github.com/lni/dragonboat/v3/raftio"
+)
+
+// memKV is a minimal, in-memory kv.IKVStore test double. It exists purely
+// to let this package's own tests drive Run/generate/compare end-to-end
+// without depending on a real RocksDB/Pebble build.
+type memKV struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newMemKV() *memKV {
+	return &memKV{m: make(map[string][]byte)}
+}
+
+func (s *memKV) Name() string { return "mem" }
+func (s *memKV) Close() error { return nil }
+
+func (s *memKV) IterateValue(fk []byte, lk []byte, inc bool,
+	op func(key []byte, data []byte) (bool, error)) error {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	vals := make(map[string][]byte, len(s.m))
+	for k, v := range s.m {
+		vals[k] = v
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		kb := []byte(k)
+		if bytes.Compare(kb, fk) < 0 {
+			continue
+		}
+		if inc {
+			if bytes.Compare(kb, lk) > 0 {
+				return nil
+			}
+		} else {
+			if bytes.Compare(kb, lk) >= 0 {
+				return nil
+			}
+		}
+		cont, err := op(kb, vals[k])
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memKV) GetValue(key []byte, op func([]byte) error) error {
+	s.mu.Lock()
+	v := s.m[string(key)]
+	s.mu.Unlock()
+	return op(v)
+}
+
+func (s *memKV) SaveValue(key []byte, value []byte) error {
+	s.mu.Lock()
+	s.m[string(key)] = append([]byte(nil), value...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memKV) DeleteValue(key []byte) error {
+	s.mu.Lock()
+	delete(s.m, string(key))
+	s.mu.Unlock()
+	return nil
+}
+
+type memWriteBatch struct {
+	puts    map[string][]byte
+	deletes map[string]struct{}
+}
+
+func (b *memWriteBatch) Put(key []byte, val []byte) {
+	delete(b.deletes, string(key))
+	b.puts[string(key)] = append([]byte(nil), val...)
+}
+
+func (b *memWriteBatch) Delete(key []byte) {
+	delete(b.puts, string(key))
+	b.deletes[string(key)] = struct{}{}
+}
+
+func (b *memWriteBatch) Clear() {
+	b.puts = make(map[string][]byte)
+	b.deletes = make(map[string]struct{})
+}
+
+func (b *memWriteBatch) Count() int {
+	return len(b.puts) + len(b.deletes)
+}
+
+func (b *memWriteBatch) Destroy() {}
+
+func (s *memKV) GetWriteBatch(ctx raftio.IContext) kv.IWriteBatch {
+	return &memWriteBatch{puts: make(map[string][]byte), deletes: make(map[string]struct{})}
+}
+
+func (s *memKV) CommitWriteBatch(wb kv.IWriteBatch) error {
+	mwb := wb.(*memWriteBatch)
+	s.mu.Lock()
+	for k, v := range mwb.puts {
+		s.m[k] = v
+	}
+	for k := range mwb.deletes {
+		delete(s.m, k)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memKV) CommitDeleteBatch(wb kv.IWriteBatch) error {
+	return s.CommitWriteBatch(wb)
+}
+
+func (s *memKV) RemoveEntries(fk []byte, lk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.m {
+		if bytes.Compare([]byte(k), fk) >= 0 && bytes.Compare([]byte(k), lk) < 0 {
+			delete(s.m, k)
+		}
+	}
+	return nil
+}
+
+func (s *memKV) Compaction(fk []byte, lk []byte) error { return nil }
+
+type memSnapshot struct {
+	m map[string][]byte
+}
+
+func (s *memKV) NewSnapshot() (kv.IKVSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string][]byte, len(s.m))
+	for k, v := range s.m {
+		m[k] = append([]byte(nil), v...)
+	}
+	return &memSnapshot{m: m}, nil
+}
+
+func (s *memSnapshot) Get(key []byte, op func([]byte) error) error {
+	return op(s.m[string(key)])
+}
+
+func (s *memSnapshot) IterateValue(fk []byte, lk []byte, inc bool,
+	op func(key []byte, data []byte) (bool, error)) error {
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kb := []byte(k)
+		if bytes.Compare(kb, fk) < 0 {
+			continue
+		}
+		if inc {
+			if bytes.Compare(kb, lk) > 0 {
+				return nil
+			}
+		} else {
+			if bytes.Compare(kb, lk) >= 0 {
+				return nil
+			}
+		}
+		cont, err := op(kb, s.m[k])
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memSnapshot) Close() {}
+
+// memOpener returns an Opener that always hands back the same *memKV
+// instance, so opClose/opReopen (a clean restart, with no StrictFS
+// configured to discard anything) preserve previously committed data
+// exactly like a real backend would.
+func memOpener() Opener {
+	backing := newMemKV()
+	return func(dir string, wal string) (kv.IKVStore, error) {
+		return backing, nil
+	}
+}
+
+func TestRunAgreesWithReference(t *testing.T) {
+	cfg := Config{Seed: 1, Ops: 500}
+	subject := Subject{Name: "mem", Open: memOpener()}
+	report, err := Run(cfg, subject)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Failed() {
+		t.Fatalf("unexpected divergence: %s", report)
+	}
+}
+
+func TestRunIsDeterministicForSameSeed(t *testing.T) {
+	cfg := Config{Seed: 42, Ops: 200}
+	r1, err := Run(cfg, Subject{Name: "a", Open: memOpener()})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	r2, err := Run(cfg, Subject{Name: "a", Open: memOpener()})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if r1.Failed() || r2.Failed() {
+		t.Fatalf("unexpected divergence: %s / %s", r1, r2)
+	}
+	if r1.Ops != r2.Ops {
+		t.Fatalf("same seed produced different op counts: %d vs %d", r1.Ops, r2.Ops)
+	}
+	// Report carries only the op count, so also compare the op streams the
+	// same seed generates.
+	if !reflect.DeepEqual(newGenerator(cfg).generate(), newGenerator(cfg).generate()) {
+		t.Fatalf("same seed produced different op streams")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import "fmt"
+
+// opType identifies the kind of operation a generator can emit.
+type opType int
+
+const (
+	opPut opType = iota
+	opDelete
+	opGet
+	opIterateValue
+	opBatchPut
+	opBatchDelete
+	opBatchCommit
+	opRemoveEntries
+	opCompaction
+	// opClose closes the store, simulates a crash via the Subject's
+	// StrictFS (discarding un-synced writes) when one is configured, then
+	// reopens it. This is the op that exercises durability semantics.
+	opClose
+	// opReopen closes the store and reopens it cleanly, with no crash
+	// simulation, exercising plain restart behavior.
+	opReopen
+)
+
+func (t opType) String() string {
+	switch t {
+	case opPut:
+		return "put"
+	case opDelete:
+		return "delete"
+	case opGet:
+		return "get"
+	case opIterateValue:
+		return "iterate-value"
+	case opBatchPut:
+		return "batch-put"
+	case opBatchDelete:
+		return "batch-delete"
+	case opBatchCommit:
+		return "batch-commit"
+	case opRemoveEntries:
+		return "remove-entries"
+	case opCompaction:
+		return "compaction"
+	case opClose:
+		return "close+crash+reopen"
+	case opReopen:
+		return "close+reopen"
+	default:
+		panic(fmt.Sprintf("unknown op type %d", t))
+	}
+}
+
+// op is a single generated operation together with the arguments it was
+// invoked with. key/lastKey/value are only populated for the op types that
+// use them.
+type op struct {
+	typ     opType
+	key     []byte
+	lastKey []byte
+	value   []byte
+	incl    bool
+}
+
+// opWeights controls the relative frequency with which each op type is
+// generated. A weight of 0 disables the op entirely.
+type opWeights struct {
+	put           int
+	delete        int
+	get           int
+	iterateValue  int
+	batchPut      int
+	batchDelete   int
+	batchCommit   int
+	removeEntries int
+	compaction    int
+	close         int
+	reopen        int
+}
+
+// defaultOpWeights returns the weights used when the caller does not supply
+// its own distribution.
+func defaultOpWeights() opWeights {
+	return opWeights{
+		put:           50,
+		delete:        15,
+		get:           20,
+		iterateValue:  10,
+		batchPut:      10,
+		batchDelete:   5,
+		batchCommit:   5,
+		removeEntries: 2,
+		compaction:    1,
+		close:         1,
+		reopen:        1,
+	}
+}
+
+func (w opWeights) total() int {
+	return w.put + w.delete + w.get + w.iterateValue +
+		w.batchPut + w.batchDelete + w.batchCommit +
+		w.removeEntries + w.compaction + w.close + w.reopen
+}
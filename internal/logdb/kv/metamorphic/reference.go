@@ -0,0 +1,86 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import (
+	"bytes"
+	"sort"
+)
+
+// refStore is a trivial in-memory reference implementation of the subset of
+// kv.IKVStore the generator exercises. It is never wrong by construction, so
+// any backend that disagrees with it (or with another backend) on the same
+// op stream has a bug.
+type refStore struct {
+	m map[string][]byte
+}
+
+func newRefStore() *refStore {
+	return &refStore{m: make(map[string][]byte)}
+}
+
+func (r *refStore) put(key []byte, value []byte) {
+	r.m[string(key)] = append([]byte(nil), value...)
+}
+
+func (r *refStore) delete(key []byte) {
+	delete(r.m, string(key))
+}
+
+func (r *refStore) get(key []byte) []byte {
+	return r.m[string(key)]
+}
+
+func (r *refStore) removeEntries(fk []byte, lk []byte) {
+	for k := range r.m {
+		if bytes.Compare([]byte(k), fk) >= 0 && bytes.Compare([]byte(k), lk) < 0 {
+			delete(r.m, k)
+		}
+	}
+}
+
+// iterateValue mirrors kv.IKVStore.IterateValue's semantics, including the
+// fact that the last key is only included when inc is true.
+func (r *refStore) iterateValue(fk []byte, lk []byte, inc bool,
+	op func(key []byte, data []byte) (bool, error)) error {
+	keys := make([]string, 0, len(r.m))
+	for k := range r.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kb := []byte(k)
+		if bytes.Compare(kb, fk) < 0 {
+			continue
+		}
+		if inc {
+			if bytes.Compare(kb, lk) > 0 {
+				return nil
+			}
+		} else {
+			if bytes.Compare(kb, lk) >= 0 {
+				return nil
+			}
+		}
+		cont, err := op(kb, r.m[k])
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import (
+	"fmt"
+
+	"github.com/lni/dragonboat/v3/internal/logdb/kv"
+)
+
+// Opener creates a fresh kv.IKVStore rooted at dir/wal. It is called once
+// to open a store and again, with the same dir/wal, after every opClose or
+// opReopen in the generated op stream -- this is what lets the harness
+// exercise durability, not just in-process correctness.
+type Opener func(dir string, wal string) (kv.IKVStore, error)
+
+// Subject is a single store under test, combined with the Opener used to
+// (re)create it. Name is a human readable name used in failure reports,
+// e.g. "pebble" or "rocksdb/small-memtest".
+type Subject struct {
+	Name   string
+	Dir    string
+	WalDir string
+	Open   Opener
+	// FS, when set, is the StrictFS the store in Dir/WalDir was opened on.
+	// opClose calls FS.Simulate before reopening so the generated op stream
+	// can exercise durability semantics; a nil FS makes opClose behave
+	// exactly like opReopen, a clean restart with nothing discarded.
+	FS *StrictFS
+}
+
+// Run replays the op stream described by cfg against every Subject plus an
+// in-memory reference implementation, and returns a Report holding the
+// first divergence found between any two of them. The Report's Divergence
+// is nil when all subjects, and the reference, agreed on every op; a nil
+// Report is only returned together with an error.
+//
+// The returned seed (cfg.Seed) plus cfg itself are enough to reproduce a
+// failure deterministically, which is what makes a failing run
+// "minimizable": cfg.Ops can be repeatedly halved while the divergence still
+// reproduces to find the smallest failing prefix.
+func Run(cfg Config, subjects ...Subject) (*Report, error) {
+	ops := newGenerator(cfg).generate()
+	ref := newRefStore()
+	refHist := newHistory("reference")
+	applyRef(ref, ops, refHist)
+
+	histories := make([]*history, 0, len(subjects)+1)
+	histories = append(histories, refHist)
+
+	for _, s := range subjects {
+		store, err := s.Open(s.Dir, s.WalDir)
+		if err != nil {
+			return nil, fmt.Errorf("metamorphic: failed to open subject %q: %w", s.Name, err)
+		}
+		h := newHistory(s.Name)
+		if err := applyStore(&store, ops, h, s); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("metamorphic: subject %q: %w", s.Name, err)
+		}
+		store.Close()
+		histories = append(histories, h)
+	}
+
+	report := &Report{Seed: cfg.Seed, Ops: len(ops)}
+	for i := 0; i < len(histories); i++ {
+		for j := i + 1; j < len(histories); j++ {
+			if d := compare(histories[i], histories[j]); d != nil {
+				report.Divergence = d
+				report.Left = histories[i].name
+				report.Right = histories[j].name
+				return report, nil
+			}
+		}
+	}
+	return report, nil
+}
+
+// Report summarizes the outcome of a Run.
+type Report struct {
+	Seed       int64
+	Ops        int
+	Left       string
+	Right      string
+	Divergence *divergence
+}
+
+// Failed reports whether any two subjects diverged.
+func (r *Report) Failed() bool {
+	return r.Divergence != nil
+}
+
+func (r *Report) String() string {
+	if !r.Failed() {
+		return fmt.Sprintf("metamorphic: %d ops, seed %d: no divergence", r.Ops, r.Seed)
+	}
+	return fmt.Sprintf("metamorphic: %d ops, seed %d: %s vs %s: %s",
+		r.Ops, r.Seed, r.Left, r.Right, r.Divergence.Error())
+}
+
+func applyRef(ref *refStore, ops []op, h *history) {
+	// batch ops reach ref only at opBatchCommit, and an uncommitted batch is
+	// dropped on opClose/opReopen, the same way applyStore handles its wb.
+	var pending []op
+	for i, o := range ops {
+		switch o.typ {
+		case opPut:
+			ref.put(o.key, o.value)
+			h.record(i, o, "ok", nil)
+		case opDelete:
+			ref.delete(o.key)
+			h.record(i, o, "ok", nil)
+		case opBatchPut, opBatchDelete:
+			pending = append(pending, o)
+			h.record(i, o, "ok", nil)
+		case opBatchCommit:
+			for _, p := range pending {
+				if p.typ == opBatchPut {
+					ref.put(p.key, p.value)
+				} else {
+					ref.delete(p.key)
+				}
+			}
+			pending = nil
+			h.record(i, o, "ok", nil)
+		case opClose, opReopen:
+			pending = nil
+			h.record(i, o, "ok", nil)
+		case opGet:
+			h.record(i, o, fmt.Sprintf("%x", ref.get(o.key)), nil)
+		case opIterateValue:
+			var out []byte
+			_ = ref.iterateValue(o.key, o.lastKey, o.incl, func(k []byte, v []byte) (bool, error) {
+				out = append(out, k...)
+				out = append(out, v...)
+				return true, nil
+			})
+			h.record(i, o, fmt.Sprintf("%x", out), nil)
+		case opRemoveEntries:
+			ref.removeEntries(o.key, o.lastKey)
+			h.record(i, o, "ok", nil)
+		case opCompaction:
+			h.record(i, o, "ok", nil)
+		}
+	}
+}
+
+// applyStore replays ops against a real kv.IKVStore, reopening it via
+// s.Open whenever an opClose/opReopen is generated -- opClose additionally
+// simulates a crash through s.FS first when one is configured -- so the
+// history also exercises the backend's durability guarantees across
+// restarts.
+func applyStore(storep *kv.IKVStore, ops []op, h *history, s Subject) error {
+	var wb kv.IWriteBatch
+	for i, o := range ops {
+		store := *storep
+		switch o.typ {
+		case opPut:
+			err := store.SaveValue(o.key, o.value)
+			h.record(i, o, "ok", err)
+		case opDelete:
+			err := store.DeleteValue(o.key)
+			h.record(i, o, "ok", err)
+		case opGet:
+			var out []byte
+			err := store.GetValue(o.key, func(v []byte) error {
+				out = append([]byte(nil), v...)
+				return nil
+			})
+			h.record(i, o, fmt.Sprintf("%x", out), err)
+		case opIterateValue:
+			var out []byte
+			err := store.IterateValue(o.key, o.lastKey, o.incl, func(k []byte, v []byte) (bool, error) {
+				out = append(out, k...)
+				out = append(out, v...)
+				return true, nil
+			})
+			h.record(i, o, fmt.Sprintf("%x", out), err)
+		case opBatchPut:
+			if wb == nil {
+				wb = store.GetWriteBatch(nil)
+			}
+			wb.Put(o.key, o.value)
+			h.record(i, o, "ok", nil)
+		case opBatchDelete:
+			if wb == nil {
+				wb = store.GetWriteBatch(nil)
+			}
+			wb.Delete(o.key)
+			h.record(i, o, "ok", nil)
+		case opBatchCommit:
+			var err error
+			if wb != nil {
+				err = store.CommitWriteBatch(wb)
+				wb = nil
+			}
+			h.record(i, o, "ok", err)
+		case opRemoveEntries:
+			err := store.RemoveEntries(o.key, o.lastKey)
+			h.record(i, o, "ok", err)
+		case opCompaction:
+			err := store.Compaction(o.key, o.lastKey)
+			h.record(i, o, "ok", err)
+		case opClose:
+			store.Close()
+			if s.FS != nil {
+				s.FS.Simulate()
+			}
+			reopened, err := s.Open(s.Dir, s.WalDir)
+			if err != nil {
+				return fmt.Errorf("failed to reopen after simulated crash: %w", err)
+			}
+			*storep = reopened
+			wb = nil
+			h.record(i, o, "ok", nil)
+		case opReopen:
+			store.Close()
+			reopened, err := s.Open(s.Dir, s.WalDir)
+			if err != nil {
+				return fmt.Errorf("failed to reopen after close: %w", err)
+			}
+			*storep = reopened
+			wb = nil
+			h.record(i, o, "ok", nil)
+		}
+	}
+	return nil
+}
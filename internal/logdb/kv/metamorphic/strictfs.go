@@ -0,0 +1,127 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import (
+	"sync"
+
+	"github.com/petermattis/pebble/vfs"
+)
+
+// StrictFS wraps a vfs.FS and tracks, per file, the bytes that have been
+// written but not yet fsync'ed. Simulate discards all such un-synced bytes,
+// emulating what an OS page cache loses on a crash. This is what lets the
+// harness turn "does the on-disk format tolerate a crash mid-write" into a
+// plain reproducible op in the history: generate some writes, call
+// Simulate, reopen, and diff against what the store claims was durable.
+//
+// It only tracks enough to support Simulate -- it is not a general purpose
+// fault injector, see errorfs for that.
+type StrictFS struct {
+	vfs.FS
+
+	mu struct {
+		sync.Mutex
+		files map[string]*strictFile
+	}
+}
+
+// NewStrictFS wraps fs so writes can be dropped on Simulate unless they were
+// synced first.
+func NewStrictFS(fs vfs.FS) *StrictFS {
+	s := &StrictFS{FS: fs}
+	s.mu.files = make(map[string]*strictFile)
+	return s
+}
+
+func (s *StrictFS) Create(name string) (vfs.File, error) {
+	f, err := s.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	// Create truncates name, so offsets tracked for an earlier file of the
+	// same name no longer apply.
+	s.mu.Lock()
+	delete(s.mu.files, name)
+	s.mu.Unlock()
+	return s.track(name, f), nil
+}
+
+func (s *StrictFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	f, err := s.FS.Open(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.track(name, f), nil
+}
+
+func (s *StrictFS) track(name string, f vfs.File) vfs.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sf, ok := s.mu.files[name]
+	if !ok {
+		sf = &strictFile{name: name, synced: 0}
+		s.mu.files[name] = sf
+	}
+	sf.File = f
+	return sf
+}
+
+// Simulate discards every byte written since each open file's last Sync,
+// as if the process had crashed before the page cache was flushed. Only
+// files whose underlying vfs.File has a Truncate method lose those bytes,
+// see crash.
+func (s *StrictFS) Simulate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.mu.files {
+		f.crash()
+	}
+}
+
+// strictFile wraps a vfs.File and remembers the highest offset that has
+// been durably synced.
+type strictFile struct {
+	vfs.File
+	name    string
+	synced  int64
+	written int64
+	crashed bool
+}
+
+func (f *strictFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *strictFile) Sync() error {
+	f.synced = f.written
+	return f.File.Sync()
+}
+
+// crash truncates the file back to the last synced offset, discarding any
+// writes made since. When the wrapped vfs.File has no Truncate method the
+// file content is left as it is and only the tracked offsets are reset.
+func (f *strictFile) crash() {
+	if f.written == f.synced {
+		return
+	}
+	if t, ok := f.File.(interface{ Truncate(int64) error }); ok {
+		_ = t.Truncate(f.synced)
+	}
+	f.written = f.synced
+	f.crashed = true
+}
@@ -0,0 +1,100 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metamorphic
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestStrictFSDiscardsUnsyncedWrites(t *testing.T) {
+	fs := NewStrictFS(vfs.NewMem())
+
+	f, err := fs.Create("foo")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("synced-")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := f.Write([]byte("unsynced")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	fs.Simulate()
+
+	rf, err := fs.Open("foo")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, err := ioutil.ReadAll(asReader(rf))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "synced-" {
+		t.Fatalf("after simulated crash got %q, want %q", got, "synced-")
+	}
+}
+
+func TestStrictFSKeepsSyncedWrites(t *testing.T) {
+	fs := NewStrictFS(vfs.NewMem())
+
+	f, err := fs.Create("foo")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("all-synced")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	fs.Simulate()
+
+	rf, err := fs.Open("foo")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, err := ioutil.ReadAll(asReader(rf))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "all-synced" {
+		t.Fatalf("after simulated crash got %q, want %q", got, "all-synced")
+	}
+}
+
+// asReader adapts a vfs.File, which only exposes ReadAt plus an unsized
+// Read, to io.Reader for ioutil.ReadAll in these tests.
+func asReader(f vfs.File) *fileReader {
+	return &fileReader{f: f}
+}
+
+type fileReader struct {
+	f   vfs.File
+	off int64
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	n, err := r.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,157 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"os"
+
+	"github.com/petermattis/pebble/vfs"
+)
+
+// OpClass identifies the category of filesystem operation an ErrorFS
+// predicate is asked to judge, letting a single Injector describe
+// partial-write and disk-full scenarios (e.g. "fail every third write", or,
+// by also matching on the path, "ENOSPC on WAL writes only").
+type OpClass int
+
+const (
+	// OpWrite covers File.Write calls.
+	OpWrite OpClass = iota
+	// OpSync covers File.Sync calls.
+	OpSync
+	// OpRead covers File.Read/ReadAt calls.
+	OpRead
+	// OpCreate covers FS.Create.
+	OpCreate
+	// OpRename covers FS.Rename, the atomic MANIFEST/WAL swap Pebble relies
+	// on -- the single most important point to inject a partial-write
+	// failure at.
+	OpRename
+	// OpLink covers FS.Link.
+	OpLink
+	// OpRemove covers FS.Remove/FS.RemoveAll.
+	OpRemove
+	// OpMkdirAll covers FS.MkdirAll.
+	OpMkdirAll
+)
+
+// Injector decides whether the op being performed on path should fail, and
+// if so with what error. A nil return from Injector means let the op
+// through to the wrapped vfs.FS.
+type Injector func(op OpClass, path string) error
+
+// ErrorFS wraps a vfs.FS and asks Inject before every tracked operation,
+// returning Inject's error instead of performing the operation when it is
+// non-nil. This is what lets a test reproduce ENOSPC/EIO mid-write or
+// mid-sync against the raft log without needing a real full disk.
+type ErrorFS struct {
+	vfs.FS
+	Inject Injector
+}
+
+// NewErrorFS wraps fs with inject as its Injector.
+func NewErrorFS(fs vfs.FS, inject Injector) *ErrorFS {
+	return &ErrorFS{FS: fs, Inject: inject}
+}
+
+func (fs *ErrorFS) Create(name string) (vfs.File, error) {
+	if err := fs.Inject(OpCreate, name); err != nil {
+		return nil, err
+	}
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &errorFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *ErrorFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	f, err := fs.FS.Open(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &errorFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *ErrorFS) Rename(oldname string, newname string) error {
+	if err := fs.Inject(OpRename, newname); err != nil {
+		return err
+	}
+	return fs.FS.Rename(oldname, newname)
+}
+
+func (fs *ErrorFS) Link(oldname string, newname string) error {
+	if err := fs.Inject(OpLink, newname); err != nil {
+		return err
+	}
+	return fs.FS.Link(oldname, newname)
+}
+
+func (fs *ErrorFS) Remove(name string) error {
+	if err := fs.Inject(OpRemove, name); err != nil {
+		return err
+	}
+	return fs.FS.Remove(name)
+}
+
+func (fs *ErrorFS) RemoveAll(name string) error {
+	if err := fs.Inject(OpRemove, name); err != nil {
+		return err
+	}
+	return fs.FS.RemoveAll(name)
+}
+
+func (fs *ErrorFS) MkdirAll(dir string, perm os.FileMode) error {
+	if err := fs.Inject(OpMkdirAll, dir); err != nil {
+		return err
+	}
+	return fs.FS.MkdirAll(dir, perm)
+}
+
+// errorFile wraps a vfs.File so that Write/Sync/Read/ReadAt on it can also
+// be failed by the owning ErrorFS's Injector.
+type errorFile struct {
+	vfs.File
+	fs   *ErrorFS
+	name string
+}
+
+func (f *errorFile) Write(p []byte) (int, error) {
+	if err := f.fs.Inject(OpWrite, f.name); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *errorFile) Sync() error {
+	if err := f.fs.Inject(OpSync, f.name); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *errorFile) Read(p []byte) (int, error) {
+	if err := f.fs.Inject(OpRead, f.name); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *errorFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.Inject(OpRead, f.name); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
@@ -0,0 +1,177 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petermattis/pebble/vfs"
+)
+
+var errInjected = errors.New("injected fault")
+
+// failOnce returns an Injector that fails the first op matching want, on
+// any path, and lets everything else through.
+func failOnce(want OpClass) Injector {
+	fired := false
+	return func(op OpClass, path string) error {
+		if op == want && !fired {
+			fired = true
+			return errInjected
+		}
+		return nil
+	}
+}
+
+func TestErrorFSCreate(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpCreate))
+	if _, err := fs.Create("foo"); err != errInjected {
+		t.Fatalf("Create: got %v, want %v", err, errInjected)
+	}
+	if _, err := fs.Create("foo"); err != nil {
+		t.Fatalf("Create after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSRename(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpRename))
+	if _, err := fs.FS.Create("foo"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Rename("foo", "bar"); err != errInjected {
+		t.Fatalf("Rename: got %v, want %v", err, errInjected)
+	}
+	if err := fs.Rename("foo", "bar"); err != nil {
+		t.Fatalf("Rename after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSLink(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpLink))
+	if _, err := fs.FS.Create("foo"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Link("foo", "bar"); err != errInjected {
+		t.Fatalf("Link: got %v, want %v", err, errInjected)
+	}
+	if err := fs.Link("foo", "bar2"); err != nil {
+		t.Fatalf("Link after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSRemove(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpRemove))
+	if _, err := fs.FS.Create("foo"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Remove("foo"); err != errInjected {
+		t.Fatalf("Remove: got %v, want %v", err, errInjected)
+	}
+	if err := fs.Remove("foo"); err != nil {
+		t.Fatalf("Remove after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSRemoveAll(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpRemove))
+	if err := fs.MkdirAll("dir", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fs.RemoveAll("dir"); err != errInjected {
+		t.Fatalf("RemoveAll: got %v, want %v", err, errInjected)
+	}
+	if err := fs.RemoveAll("dir"); err != nil {
+		t.Fatalf("RemoveAll after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSMkdirAll(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpMkdirAll))
+	if err := fs.MkdirAll("dir", 0755); err != errInjected {
+		t.Fatalf("MkdirAll: got %v, want %v", err, errInjected)
+	}
+	if err := fs.MkdirAll("dir", 0755); err != nil {
+		t.Fatalf("MkdirAll after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSWriteAndSync(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpWrite))
+	f, err := fs.Create("foo")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte("x")); err != errInjected {
+		t.Fatalf("Write: got %v, want %v", err, errInjected)
+	}
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Fatalf("Write after fault cleared: got %v, want nil", err)
+	}
+
+	fs2 := NewErrorFS(vfs.NewMem(), failOnce(OpSync))
+	f2, err := fs2.Create("foo")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := f2.Sync(); err != errInjected {
+		t.Fatalf("Sync: got %v, want %v", err, errInjected)
+	}
+	if err := f2.Sync(); err != nil {
+		t.Fatalf("Sync after fault cleared: got %v, want nil", err)
+	}
+}
+
+func TestErrorFSReadAndReadAt(t *testing.T) {
+	fs := NewErrorFS(vfs.NewMem(), failOnce(OpRead))
+	wf, err := fs.Create("foo")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := wf.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	rf, err := fs.Open("foo")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := rf.Read(buf); err != errInjected {
+		t.Fatalf("Read: got %v, want %v", err, errInjected)
+	}
+	if _, err := rf.Read(buf); err != nil {
+		t.Fatalf("Read after fault cleared: got %v, want nil", err)
+	}
+
+	fs2 := NewErrorFS(vfs.NewMem(), failOnce(OpRead))
+	wf2, err := fs2.Create("bar")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := wf2.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	rf2, err := fs2.Open("bar")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := rf2.ReadAt(buf, 0); err != errInjected {
+		t.Fatalf("ReadAt: got %v, want %v", err, errInjected)
+	}
+	if _, err := rf2.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt after fault cleared: got %v, want nil", err)
+	}
+}
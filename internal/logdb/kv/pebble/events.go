@@ -0,0 +1,61 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"github.com/lni/dragonboat/v3/internal/logger"
+	"github.com/petermattis/pebble"
+)
+
+var plog = logger.GetLogger("pebblekv")
+
+// EventListenerFunc lets callers observe flush/compaction/WAL-rotation
+// events without needing to know pebble.EventListener's full shape.
+type EventListenerFunc func(format string, args ...interface{})
+
+// newEventListener builds a pebble.EventListener that forwards flush begin/end,
+// compaction begin/end and WAL created/deleted events to plog and, if cb is
+// non-nil, to the caller-supplied callback too. Other events are not
+// forwarded. Prior
+// to this the options struct was built privately inside openPebbleDB and
+// none of flush/compaction/WAL activity was observable.
+func newEventListener(cb EventListenerFunc) pebble.EventListener {
+	forward := func(format string, args ...interface{}) {
+		plog.Infof(format, args...)
+		if cb != nil {
+			cb(format, args...)
+		}
+	}
+	return pebble.EventListener{
+		FlushBegin: func(info pebble.FlushInfo) {
+			forward("pebble: flush begin: %s", info)
+		},
+		FlushEnd: func(info pebble.FlushInfo) {
+			forward("pebble: flush end: %s", info)
+		},
+		CompactionBegin: func(info pebble.CompactionInfo) {
+			forward("pebble: compaction begin: %s", info)
+		},
+		CompactionEnd: func(info pebble.CompactionInfo) {
+			forward("pebble: compaction end: %s", info)
+		},
+		WALCreated: func(info pebble.WALCreateInfo) {
+			forward("pebble: wal created: %s", info)
+		},
+		WALDeleted: func(info pebble.WALDeleteInfo) {
+			forward("pebble: wal deleted: %s", info)
+		},
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"bytes"
 	"fmt"
 
-	"github.com/lni/dragonboat/internal/logdb/kv"
-	"github.com/lni/dragonboat/raftio"
+	"github.com/lni/dragonboat/v3/config"
+	"github.com/lni/dragonboat/v3/internal/logdb/kv"
+	"github.com/lni/dragonboat/v3/raftio"
 	"github.com/petermattis/pebble"
 	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
 )
 
 type pebbleWriteBatch struct {
@@ -56,8 +58,49 @@ func (w *pebbleWriteBatch) Count() int {
 	return w.count
 }
 
-func NewKVStore(dir string, wal string) (kv.IKVStore, error) {
-	return openPebbleDB(dir, wal)
+// Options carries everything needed to open a PebbleKV instance beyond the
+// generic config.LogDBConfig knobs: an optional vfs.FS (used by tests to
+// inject crashes or I/O errors) and an optional EventListenerFunc used to
+// observe flush/compaction/WAL-rotation activity.
+type Options struct {
+	config.LogDBConfig
+	// FS is the virtual filesystem pebble opens its store on. A nil FS
+	// causes pebble's default, the real OS filesystem, to be used.
+	FS vfs.FS
+	// EventCallback, if set, is additionally invoked for every flush,
+	// compaction and WAL created/deleted event, on top of the standard plog
+	// logging.
+	EventCallback EventListenerFunc
+}
+
+// NewKVStore creates a new pebble based IKVStore instance. cfg is optional;
+// when not supplied config.GetDefaultLogDBConfig() is used, matching the
+// rocksdb backend's behavior.
+func NewKVStore(dir string, wal string, cfg ...config.LogDBConfig) (kv.IKVStore, error) {
+	c := config.GetDefaultLogDBConfig()
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	return NewKVStoreWithOptions(dir, wal, Options{LogDBConfig: c})
+}
+
+// NewKVStoreFromPreset is like NewKVStore but resolves cfg from one of
+// config.LogDBPresets by name (e.g. "small-mem-test", "large-mem-production")
+// instead of requiring the caller to build a config.LogDBConfig by hand.
+func NewKVStoreFromPreset(dir string, wal string, preset string) (kv.IKVStore, error) {
+	c, ok := config.GetLogDBConfig(preset)
+	if !ok {
+		return nil, fmt.Errorf("pebble: unknown LogDBConfig preset %q", preset)
+	}
+	return NewKVStoreWithOptions(dir, wal, Options{LogDBConfig: c})
+}
+
+// NewKVStoreWithOptions is like NewKVStore but also accepts Options'
+// vfs.FS and EventCallback, which NewKVStore has no way to express. It lets
+// tests open the store on a StrictFS/ErrorFS, and lets operators observe
+// compaction activity in production.
+func NewKVStoreWithOptions(dir string, wal string, opts Options) (kv.IKVStore, error) {
+	return openPebbleDB(dir, wal, opts)
 }
 
 type PebbleKV struct {
@@ -67,26 +110,85 @@ type PebbleKV struct {
 	wo   *db.WriteOptions
 }
 
-func openPebbleDB(dir string, walDir string) (*PebbleKV, error) {
-	fmt.Printf("pebble support is experimental, DO NOT USE IN PRODUCTION\n")
-	lopts := db.LevelOptions{Compression: db.NoCompression}
-	opts := &db.Options{
-		Levels: []db.LevelOptions{lopts},
+func toDBCompression(t config.CompressionType) db.Compression {
+	switch t {
+	case config.SnappyCompression, config.ZstdCompression:
+		// the petermattis/pebble fork vendored here only implements Snappy
+		// block compression, Zstd is mapped onto it until upstream adds
+		// native support.
+		return db.SnappyCompression
+	default:
+		return db.NoCompression
+	}
+}
+
+// toDBLevels builds cfg.KVNumOfLevels LevelOptions entries, with each
+// level's target file size scaled by cfg.KVTargetFileSizeMultiplier, so
+// that KVNumOfLevels actually changes the shape of dbopts.Levels instead of
+// Pebble always seeing a single, L0-only level.
+func toDBLevels(cfg config.LogDBConfig) []db.LevelOptions {
+	n := int(cfg.KVNumOfLevels)
+	if n < 1 {
+		n = 1
+	}
+	mul := int64(cfg.KVTargetFileSizeMultiplier)
+	if mul < 1 {
+		mul = 1
+	}
+	levels := make([]db.LevelOptions, n)
+	targetFileSize := int64(cfg.KVTargetFileSizeBase)
+	for i := 0; i < n; i++ {
+		levels[i] = db.LevelOptions{
+			Compression:    toDBCompression(cfg.KVCompressionType),
+			BlockSize:      int(cfg.KVBlockSize),
+			TargetFileSize: targetFileSize,
+		}
+		targetFileSize *= mul
+	}
+	return levels
+}
+
+func toDBOptions(dir string, walDir string, opts Options) *db.Options {
+	cfg := opts.LogDBConfig
+	dbopts := &db.Options{
+		Levels:                           toDBLevels(cfg),
+		MemTableSize:                     int(cfg.KVWriteBufferSize),
+		MemTableStopWritesThreshold:      int(cfg.KVMaxWriteBufferNumber),
+		L0CompactionThreshold:            int(cfg.KVLevel0FileNumCompactionTrigger),
+		L0SlowdownWritesThreshold:        int(cfg.KVLevel0SlowdownWritesTrigger),
+		L0StopWritesThreshold:            int(cfg.KVLevel0StopWritesTrigger),
+		MaxOpenFiles:                     int(cfg.KVMaxOpenFiles),
+		WALBytesPerSync:                  int(cfg.KVWALBytesPerSync),
+		MaxBytesForLevelBase:             int64(cfg.KVMaxBytesForLevelBase),
+		LevelCompactionDynamicLevelBytes: cfg.KVLevelCompactionDynamicLevelBytes != 0,
+		EventListener:                    newEventListener(opts.EventCallback),
+	}
+	if cfg.KVLRUCacheSize > 0 {
+		dbopts.Cache = db.NewCache(int64(cfg.KVLRUCacheSize))
 	}
 	if len(walDir) > 0 {
-		opts.WALDir = walDir
+		dbopts.WALDir = walDir
+	}
+	if opts.FS != nil {
+		dbopts.FS = opts.FS
 	}
-	pdb, err := pebble.Open(dir, opts)
+	return dbopts
+}
+
+func openPebbleDB(dir string, walDir string, opts Options) (*PebbleKV, error) {
+	fmt.Printf("pebble support is experimental, DO NOT USE IN PRODUCTION\n")
+	dbopts := toDBOptions(dir, walDir, opts)
+	pdb, err := pebble.Open(dir, dbopts)
 	if err != nil {
 		return nil, err
 	}
 	ro := &db.IterOptions{}
-	wo := &db.WriteOptions{Sync: true}
+	wo := &db.WriteOptions{Sync: opts.Sync}
 	return &PebbleKV{
 		db:   pdb,
 		ro:   ro,
 		wo:   wo,
-		opts: opts,
+		opts: dbopts,
 	}, nil
 }
 
@@ -177,15 +279,21 @@ func (r *PebbleKV) CommitDeleteBatch(wb kv.IWriteBatch) error {
 	return r.CommitWriteBatch(wb)
 }
 
+// RemoveEntries scans [fk, lk) off a snapshot rather than the live db, so
+// the range it deletes is the one that existed when the call started even
+// if new entries land in that range while the scan is still building wb.
 func (r *PebbleKV) RemoveEntries(fk []byte, lk []byte) error {
-	iter := r.db.NewIter(r.ro)
-	defer iter.Close()
+	snap, err := r.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
 	wb := r.GetWriteBatch(nil)
-	for iter.SeekGE(fk); iteratorIsValid(iter); iter.Next() {
-		if bytes.Compare(iter.Key(), lk) >= 0 {
-			break
-		}
-		wb.Delete(iter.Key())
+	if err := snap.IterateValue(fk, lk, false, func(key []byte, data []byte) (bool, error) {
+		wb.Delete(key)
+		return true, nil
+	}); err != nil {
+		return err
 	}
 	if wb.Count() > 0 {
 		return r.CommitDeleteBatch(wb)
@@ -195,4 +303,56 @@ func (r *PebbleKV) RemoveEntries(fk []byte, lk []byte) error {
 
 func (r *PebbleKV) Compaction(fk []byte, lk []byte) error {
 	return r.db.Compact(fk, lk)
-}
\ No newline at end of file
+}
+
+// pebbleSnapshot implements kv.IKVSnapshot over a pebble.Snapshot, giving
+// callers a consistent point-in-time view of the store even while
+// SaveValue/CommitWriteBatch keep mutating it.
+type pebbleSnapshot struct {
+	snap *pebble.Snapshot
+	ro   *db.IterOptions
+}
+
+// NewSnapshot returns a consistent, read-only view of the store.
+func (r *PebbleKV) NewSnapshot() (kv.IKVSnapshot, error) {
+	return &pebbleSnapshot{snap: r.db.NewSnapshot(), ro: r.ro}, nil
+}
+
+func (s *pebbleSnapshot) Get(key []byte, op func([]byte) error) error {
+	val, err := s.snap.Get(key)
+	if err != nil && err != db.ErrNotFound {
+		return err
+	}
+	return op(val)
+}
+
+func (s *pebbleSnapshot) IterateValue(fk []byte, lk []byte, inc bool,
+	op func(key []byte, data []byte) (bool, error)) error {
+	iter := s.snap.NewIter(s.ro)
+	defer iter.Close()
+	for iter.SeekGE(fk); iteratorIsValid(iter); iter.Next() {
+		key := iter.Key()
+		val := iter.Value()
+		if inc {
+			if bytes.Compare(key, lk) > 0 {
+				return nil
+			}
+		} else {
+			if bytes.Compare(key, lk) >= 0 {
+				return nil
+			}
+		}
+		cont, err := op(key, val)
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *pebbleSnapshot) Close() {
+	s.snap.Close()
+}
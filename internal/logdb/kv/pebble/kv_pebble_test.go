@@ -0,0 +1,139 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pebble
+
+import (
+	"testing"
+)
+
+func mustOpenTestDB(t *testing.T, dir string) *PebbleKV {
+	store, err := NewKVStore(dir, "")
+	if err != nil {
+		t.Fatalf("failed to open pebble store: %v", err)
+	}
+	return store.(*PebbleKV)
+}
+
+func TestSnapshotObservesConsistentView(t *testing.T) {
+	dir := t.TempDir()
+	kv := mustOpenTestDB(t, dir)
+	defer kv.Close()
+
+	if err := kv.SaveValue([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatalf("SaveValue failed: %v", err)
+	}
+
+	snap, err := kv.NewSnapshot()
+	if err != nil {
+		t.Fatalf("NewSnapshot failed: %v", err)
+	}
+	defer snap.Close()
+
+	// a write made after the snapshot was taken must not be visible
+	// through it, but must be visible through the live store.
+	if err := kv.SaveValue([]byte("k1"), []byte("v2")); err != nil {
+		t.Fatalf("SaveValue failed: %v", err)
+	}
+	if err := kv.DeleteValue([]byte("does-not-exist")); err != nil {
+		t.Fatalf("DeleteValue failed: %v", err)
+	}
+
+	var snapVal []byte
+	if err := snap.Get([]byte("k1"), func(v []byte) error {
+		snapVal = append([]byte(nil), v...)
+		return nil
+	}); err != nil {
+		t.Fatalf("snapshot Get failed: %v", err)
+	}
+	if string(snapVal) != "v1" {
+		t.Fatalf("snapshot observed %q, want %q", snapVal, "v1")
+	}
+
+	var liveVal []byte
+	if err := kv.GetValue([]byte("k1"), func(v []byte) error {
+		liveVal = append([]byte(nil), v...)
+		return nil
+	}); err != nil {
+		t.Fatalf("live GetValue failed: %v", err)
+	}
+	if string(liveVal) != "v2" {
+		t.Fatalf("live store observed %q, want %q", liveVal, "v2")
+	}
+}
+
+func TestSnapshotIterateValue(t *testing.T) {
+	dir := t.TempDir()
+	kv := mustOpenTestDB(t, dir)
+	defer kv.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := kv.SaveValue([]byte(k), []byte(k+"-value")); err != nil {
+			t.Fatalf("SaveValue(%q) failed: %v", k, err)
+		}
+	}
+
+	snap, err := kv.NewSnapshot()
+	if err != nil {
+		t.Fatalf("NewSnapshot failed: %v", err)
+	}
+	defer snap.Close()
+
+	if err := kv.SaveValue([]byte("d"), []byte("d-value")); err != nil {
+		t.Fatalf("SaveValue failed: %v", err)
+	}
+
+	var keys []string
+	if err := snap.IterateValue([]byte("a"), []byte("z"), true,
+		func(key []byte, data []byte) (bool, error) {
+			keys = append(keys, string(key))
+			return true, nil
+		}); err != nil {
+		t.Fatalf("snapshot IterateValue failed: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("snapshot iterated %d keys, want 3 (got %v)", len(keys), keys)
+	}
+}
+
+func TestRemoveEntriesDeletesRangeViaSnapshotScan(t *testing.T) {
+	dir := t.TempDir()
+	kv := mustOpenTestDB(t, dir)
+	defer kv.Close()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := kv.SaveValue([]byte(k), []byte(k+"-value")); err != nil {
+			t.Fatalf("SaveValue(%q) failed: %v", k, err)
+		}
+	}
+
+	if err := kv.RemoveEntries([]byte("a"), []byte("c")); err != nil {
+		t.Fatalf("RemoveEntries failed: %v", err)
+	}
+
+	for k, wantRemoved := range map[string]bool{
+		"a": true, "b": true, "c": false, "d": false,
+	} {
+		var val []byte
+		if err := kv.GetValue([]byte(k), func(v []byte) error {
+			val = append([]byte(nil), v...)
+			return nil
+		}); err != nil {
+			t.Fatalf("GetValue(%q) failed: %v", k, err)
+		}
+		if removed := val == nil; removed != wantRemoved {
+			t.Fatalf("key %q: removed=%v, want %v", k, removed, wantRemoved)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com) and other Dragonboat authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsm
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lni/dragonboat/v3/config"
+	sm "github.com/lni/dragonboat/v3/statemachine"
+)
+
+// KV is a single key-value mutation produced by applying one batch of Raft
+// log entries to an on disk state machine.
+type KV struct {
+	Key   []byte
+	Value []byte
+	Del   bool
+}
+
+// IMutationRecorder is implemented by an IOnDiskStateMachine that wants the
+// mutations made by its most recent Update call to be cached by a
+// DiffLayerCache. An IOnDiskStateMachine that does not implement it can
+// still be wrapped by NewDiffLayerCache -- no diff layer is ever created
+// for it and every Lookup/NALookup call simply falls through to the SM, so
+// the feature is a no-op for SMs that have not opted in.
+type IMutationRecorder interface {
+	// Mutations returns the key-value writes and deletes applied by the
+	// most recently completed Update call.
+	Mutations() []KV
+}
+
+// diffLayer is a single, immutable once built, batch of mutations kept in
+// memory on top of the disk state machine. It mirrors the "diff layer" of
+// Ethereum's state snapshot design: cheap to create, cheap to search, and
+// eventually discarded once its data is known to already be durable on the
+// disk layer below it.
+type diffLayer struct {
+	kv      map[string]KV
+	bytes   uint64
+	created time.Time
+}
+
+func newDiffLayer(muts []KV) *diffLayer {
+	l := &diffLayer{
+		kv:      make(map[string]KV, len(muts)),
+		created: time.Now(),
+	}
+	for _, kv := range muts {
+		l.kv[string(kv.Key)] = kv
+		l.bytes += uint64(len(kv.Key) + len(kv.Value))
+	}
+	return l
+}
+
+func (l *diffLayer) get(key []byte) (KV, bool) {
+	v, ok := l.kv[string(key)]
+	return v, ok
+}
+
+// DiffLayerCache sits between OnDiskStateMachine.Update and the user's
+// sm.IOnDiskStateMachine. It keeps a bounded stack of in-memory diffLayers
+// built from the mutations of recent Update calls so that NALookup can be
+// served from memory instead of hitting the on disk KV store on every call.
+//
+// This is a read-cache only: every Update is still applied to the
+// underlying state machine synchronously and a diff layer only caches what
+// is already durable there. It does NOT defer or write-batch Update calls
+// into the underlying SM -- shrink() below drops layers that are already
+// fully applied, it never merges/flushes buffered writes into c.sm.
+type DiffLayerCache struct {
+	mu     sync.RWMutex
+	layers []*diffLayer // front (index 0) is the newest layer
+	total  uint64
+
+	sm  sm.IOnDiskStateMachine
+	cfg config.DiffLayerConfig
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+	donec    chan struct{}
+}
+
+// NewDiffLayerCache wraps s with a difflayer cache configured by cfg. When
+// cfg.Enabled() is false the returned cache never buffers anything and
+// every call is a direct pass-through to s.
+func NewDiffLayerCache(s sm.IOnDiskStateMachine, cfg config.DiffLayerConfig) *DiffLayerCache {
+	c := &DiffLayerCache{
+		sm:    s,
+		cfg:   cfg,
+		stopc: make(chan struct{}),
+		donec: make(chan struct{}),
+	}
+	if cfg.Enabled() {
+		go c.flusher()
+	} else {
+		close(c.donec)
+	}
+	return c
+}
+
+// Close stops the background flusher, if any, started by NewDiffLayerCache.
+func (c *DiffLayerCache) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stopc)
+	})
+	<-c.donec
+}
+
+// Reset discards every diff layer currently held, without touching the
+// underlying state machine. It must be called whenever the underlying SM's
+// state changes by a means other than Update -- most notably after
+// RecoverFromSnapshot installs a new snapshot -- so that NALookup does not
+// keep serving mutations from before the install on top of the now
+// unrelated recovered state.
+func (c *DiffLayerCache) Reset() {
+	c.mu.Lock()
+	c.layers = nil
+	c.total = 0
+	c.mu.Unlock()
+}
+
+// Update applies entries to the underlying state machine synchronously and,
+// when the SM implements IMutationRecorder, pushes the resulting mutations
+// as a new top diff layer for NALookup to serve reads from. It does not
+// defer or batch the write to the underlying SM -- see the DiffLayerCache
+// doc comment.
+func (c *DiffLayerCache) Update(entries []sm.Entry) ([]sm.Entry, error) {
+	entries, err := c.sm.Update(entries)
+	if err != nil || !c.cfg.Enabled() {
+		return entries, err
+	}
+	rec, ok := c.sm.(IMutationRecorder)
+	if !ok {
+		return entries, nil
+	}
+	if muts := rec.Mutations(); len(muts) > 0 {
+		c.push(newDiffLayer(muts))
+	}
+	return entries, nil
+}
+
+// push prepends l as the new top layer and immediately trims the stack
+// against cfg's bounds, so MaxDiffLayers/DiffLayerBytes are enforced on
+// every Update rather than only at the next flusher tick -- otherwise a
+// burst of Updates between ticks could grow the stack past its configured
+// bound.
+func (c *DiffLayerCache) push(l *diffLayer) {
+	c.mu.Lock()
+	c.layers = append([]*diffLayer{l}, c.layers...)
+	c.total += l.bytes
+	c.shrinkLocked()
+	c.mu.Unlock()
+}
+
+// Lookup queries the state machine directly. Generic queries are opaque to
+// the cache -- only the byte-keyed NALookup path is cacheable.
+func (c *DiffLayerCache) Lookup(query interface{}) (interface{}, error) {
+	return c.sm.Lookup(query)
+}
+
+// NALookup first walks the in-memory diff layers top-down, returning the
+// first hit found, before falling through to the underlying state machine.
+func (c *DiffLayerCache) NALookup(query []byte) ([]byte, error) {
+	if c.cfg.Enabled() {
+		if kv, ok := c.search(query); ok {
+			if kv.Del {
+				return nil, nil
+			}
+			return kv.Value, nil
+		}
+	}
+	na, ok := c.sm.(sm.IExtended)
+	if !ok {
+		return nil, sm.ErrNotImplemented
+	}
+	return na.NALookup(query)
+}
+
+func (c *DiffLayerCache) search(key []byte) (KV, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range c.layers {
+		if kv, ok := l.get(key); ok {
+			return kv, true
+		}
+	}
+	return KV{}, false
+}
+
+// Prepare returns the underlying SM's own preparation context. The cached
+// diff layers are not part of what gets snapshotted -- every mutation they
+// hold was already durably applied to the underlying SM by Update, so the
+// SM's own Prepare/Save pair is already complete and consistent on its own;
+// there is nothing in the layer stack for Prepare to additionally capture.
+func (c *DiffLayerCache) Prepare() (interface{}, error) {
+	return c.sm.PrepareSnapshot()
+}
+
+// Save delegates to the underlying state machine using the context Prepare
+// produced.
+func (c *DiffLayerCache) Save(ctx interface{},
+	w io.Writer, stopc <-chan struct{}) error {
+	return c.sm.SaveSnapshot(ctx, w, stopc)
+}
+
+// flusher periodically pops the bottom diff layer once it is old enough or
+// the stack has grown too large, bounding the cache's memory footprint.
+// Popping a layer never touches the underlying SM -- its data is already
+// durable there, so a flush is just forgetting what is safe to forget.
+func (c *DiffLayerCache) flusher() {
+	defer close(c.donec)
+	interval := c.cfg.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopc:
+			return
+		case <-ticker.C:
+			c.shrink()
+		}
+	}
+}
+
+func (c *DiffLayerCache) shrink() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shrinkLocked()
+}
+
+// shrinkLocked pops layers off the bottom of the stack while they are too
+// old or the stack is over its count/byte bound. c.mu must be held by the
+// caller.
+func (c *DiffLayerCache) shrinkLocked() {
+	for len(c.layers) > 0 {
+		bottom := c.layers[len(c.layers)-1]
+		tooMany := uint64(len(c.layers)) > c.cfg.MaxDiffLayers
+		tooOld := c.cfg.FlushInterval > 0 && time.Since(bottom.created) >= c.cfg.FlushInterval
+		tooBig := c.cfg.DiffLayerBytes > 0 && c.total >= c.cfg.DiffLayerBytes
+		if !tooMany && !tooOld && !tooBig {
+			return
+		}
+		c.layers = c.layers[:len(c.layers)-1]
+		c.total -= bottom.bytes
+	}
+}
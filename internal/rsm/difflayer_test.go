@@ -0,0 +1,162 @@
+// Copyright 2017-2019 Lei Ni (nilei81@gmail.com) and other Dragonboat authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsm
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lni/dragonboat/v3/config"
+	sm "github.com/lni/dragonboat/v3/statemachine"
+)
+
+// fakeOnDiskSM is a minimal sm.IOnDiskStateMachine test double that also
+// implements IMutationRecorder and sm.IExtended, so it can drive every
+// DiffLayerCache code path without needing a real on disk state machine.
+type fakeOnDiskSM struct {
+	kv   map[string][]byte
+	muts []KV
+}
+
+func newFakeOnDiskSM() *fakeOnDiskSM {
+	return &fakeOnDiskSM{kv: make(map[string][]byte)}
+}
+
+func (s *fakeOnDiskSM) Open(stopc <-chan struct{}) (uint64, error) { return 0, nil }
+
+func (s *fakeOnDiskSM) Update(entries []sm.Entry) ([]sm.Entry, error) {
+	s.muts = s.muts[:0]
+	for _, e := range entries {
+		kv := KV{Key: e.Cmd, Value: e.Cmd}
+		s.kv[string(kv.Key)] = kv.Value
+		s.muts = append(s.muts, kv)
+	}
+	return entries, nil
+}
+
+func (s *fakeOnDiskSM) Lookup(query interface{}) (interface{}, error) {
+	return s.kv[string(query.([]byte))], nil
+}
+
+func (s *fakeOnDiskSM) NALookup(query []byte) ([]byte, error) {
+	return s.kv[string(query)], nil
+}
+
+func (s *fakeOnDiskSM) Sync() error { return nil }
+
+func (s *fakeOnDiskSM) PrepareSnapshot() (interface{}, error) { return nil, nil }
+
+func (s *fakeOnDiskSM) SaveSnapshot(ctx interface{},
+	w io.Writer, stopc <-chan struct{}) error {
+	return nil
+}
+
+func (s *fakeOnDiskSM) RecoverFromSnapshot(io.Reader, <-chan struct{}) error { return nil }
+
+func (s *fakeOnDiskSM) Close() {}
+
+func (s *fakeOnDiskSM) Mutations() []KV { return s.muts }
+
+func testCfg() config.DiffLayerConfig {
+	return config.DiffLayerConfig{
+		MaxDiffLayers:  2,
+		DiffLayerBytes: 1 << 20,
+		FlushInterval:  time.Hour,
+	}
+}
+
+func TestNALookupServesFromDiffLayerBeforeFallingThroughToSM(t *testing.T) {
+	s := newFakeOnDiskSM()
+	c := NewDiffLayerCache(s, testCfg())
+	defer c.Close()
+
+	if _, err := c.Update([]sm.Entry{{Cmd: []byte("k1")}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	// mutate the underlying SM directly, bypassing Update/push, so a hit
+	// can only come from the diff layer built above, not a fallthrough.
+	s.kv["k1"] = []byte("stale")
+
+	got, err := c.NALookup([]byte("k1"))
+	if err != nil {
+		t.Fatalf("NALookup failed: %v", err)
+	}
+	if string(got) != "k1" {
+		t.Fatalf("NALookup = %q, want %q (served from diff layer)", got, "k1")
+	}
+}
+
+func TestNALookupFallsThroughWhenCacheDisabled(t *testing.T) {
+	s := newFakeOnDiskSM()
+	c := NewDiffLayerCache(s, config.DiffLayerConfig{})
+	defer c.Close()
+
+	if _, err := c.Update([]sm.Entry{{Cmd: []byte("k1")}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	s.kv["k1"] = []byte("updated-directly")
+
+	got, err := c.NALookup([]byte("k1"))
+	if err != nil {
+		t.Fatalf("NALookup failed: %v", err)
+	}
+	if string(got) != "updated-directly" {
+		t.Fatalf("NALookup = %q, want %q (disabled cache must fall through)", got, "updated-directly")
+	}
+}
+
+func TestResetDropsDiffLayersWithoutTouchingSM(t *testing.T) {
+	s := newFakeOnDiskSM()
+	c := NewDiffLayerCache(s, testCfg())
+	defer c.Close()
+
+	if _, err := c.Update([]sm.Entry{{Cmd: []byte("k1")}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	c.Reset()
+	s.kv["k1"] = []byte("after-recover")
+
+	got, err := c.NALookup([]byte("k1"))
+	if err != nil {
+		t.Fatalf("NALookup failed: %v", err)
+	}
+	if string(got) != "after-recover" {
+		t.Fatalf("NALookup = %q, want %q (Reset must drop the stale layer)", got, "after-recover")
+	}
+}
+
+func TestPushEnforcesMaxDiffLayersImmediately(t *testing.T) {
+	s := newFakeOnDiskSM()
+	cfg := testCfg()
+	c := NewDiffLayerCache(s, cfg)
+	defer c.Close()
+
+	// push more layers than MaxDiffLayers in a single burst, well inside
+	// one FlushInterval, so only push()'s own bound enforcement -- not the
+	// periodic flusher -- can be responsible for trimming the stack.
+	for i := 0; i < 5; i++ {
+		if _, err := c.Update([]sm.Entry{{Cmd: []byte{byte(i)}}}); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	c.mu.RLock()
+	n := len(c.layers)
+	c.mu.RUnlock()
+	if uint64(n) > cfg.MaxDiffLayers {
+		t.Fatalf("layer count = %d, want at most %d", n, cfg.MaxDiffLayers)
+	}
+}
@@ -257,11 +257,16 @@ type OnDiskStateMachine struct {
 	sm     sm.IOnDiskStateMachine
 	h      sm.IHash
 	na     sm.IExtended
+	cache  *DiffLayerCache
 	opened bool
 }
 
-// NewOnDiskStateMachine creates and returns an on disk state machine.
-func NewOnDiskStateMachine(s sm.IOnDiskStateMachine) *OnDiskStateMachine {
+// NewOnDiskStateMachine creates and returns an on disk state machine. cfg is
+// optional and controls the difflayer read cache described by
+// config.DiffLayerConfig; when not supplied, or when its zero value
+// disables the cache, this behaves exactly as before the cache existed.
+func NewOnDiskStateMachine(s sm.IOnDiskStateMachine,
+	cfg ...config.DiffLayerConfig) *OnDiskStateMachine {
 	r := &OnDiskStateMachine{sm: s}
 	h, ok := s.(sm.IHash)
 	if ok {
@@ -271,6 +276,9 @@ func NewOnDiskStateMachine(s sm.IOnDiskStateMachine) *OnDiskStateMachine {
 	if ok {
 		r.na = na
 	}
+	if len(cfg) > 0 && cfg[0].Enabled() {
+		r.cache = NewDiffLayerCache(s, cfg[0])
+	}
 	return r
 }
 
@@ -301,6 +309,9 @@ func (s *OnDiskStateMachine) Update(entries []sm.Entry) ([]sm.Entry, error) {
 	if !s.opened {
 		panic("Update called before Open")
 	}
+	if s.cache != nil {
+		return s.cache.Update(entries)
+	}
 	return s.sm.Update(entries)
 }
 
@@ -309,11 +320,17 @@ func (s *OnDiskStateMachine) Lookup(query interface{}) (interface{}, error) {
 	if !s.opened {
 		panic("Lookup called when not opened")
 	}
+	if s.cache != nil {
+		return s.cache.Lookup(query)
+	}
 	return s.sm.Lookup(query)
 }
 
 // NALookup queries the state machine.
 func (s *OnDiskStateMachine) NALookup(query []byte) ([]byte, error) {
+	if s.cache != nil {
+		return s.cache.NALookup(query)
+	}
 	if s.na == nil {
 		return nil, sm.ErrNotImplemented
 	}
@@ -333,6 +350,9 @@ func (s *OnDiskStateMachine) Prepare() (interface{}, error) {
 	if !s.opened {
 		panic("PrepareSnapshot called when not opened")
 	}
+	if s.cache != nil {
+		return s.cache.Prepare()
+	}
 	return s.sm.PrepareSnapshot()
 }
 
@@ -342,6 +362,9 @@ func (s *OnDiskStateMachine) Save(ctx interface{},
 	if !s.opened {
 		panic("SaveSnapshot called when not opened")
 	}
+	if s.cache != nil {
+		return s.cache.Save(ctx, w, stopc)
+	}
 	return s.sm.SaveSnapshot(ctx, w, stopc)
 }
 
@@ -351,11 +374,23 @@ func (s *OnDiskStateMachine) Recover(r io.Reader,
 	if !s.opened {
 		panic("RecoverFromSnapshot called when not opened")
 	}
-	return s.sm.RecoverFromSnapshot(r, stopc)
+	if err := s.sm.RecoverFromSnapshot(r, stopc); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		// the installed snapshot makes every diff layer accumulated before
+		// Recover stale, NALookup must not keep serving them on top of the
+		// freshly recovered SM.
+		s.cache.Reset()
+	}
+	return nil
 }
 
 // Close closes the state machine.
 func (s *OnDiskStateMachine) Close() error {
+	if s.cache != nil {
+		s.cache.Close()
+	}
 	return s.sm.Close()
 }
 